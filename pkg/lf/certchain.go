@@ -0,0 +1,144 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import "crypto/x509"
+
+// Certificate is one X.509 certificate in a chain attached to a record to authorize it against a network's
+// root(s) of trust. It is intentionally just the DER bytes rather than a parsed *x509.Certificate so that
+// Record's on-the-wire encoding doesn't have to carry (or re-serialize) anything beyond what was signed.
+//
+// A chain of Certificate (as stored in Record.CertChains) must be ordered leaf-first: each certificate is
+// issued by the one after it, ending in the root. pickChain relies on this to find a chain's root.
+type Certificate struct {
+	Raw []byte
+}
+
+// IssuerCommonName returns the CommonName of this certificate's issuer, or "" if Raw does not parse as a
+// valid X.509 certificate.
+func (c Certificate) IssuerCommonName() string {
+	cert, err := x509.ParseCertificate(c.Raw)
+	if err != nil {
+		return ""
+	}
+	return cert.Issuer.CommonName
+}
+
+// PreferredCertIssuers is the node-config knob that drives pickChain: the issuer common names a node's
+// operator trusts, in preference order. A node with no preference configured (the zero value) falls back
+// to whatever non-empty chain a record happens to carry, which is what every node did before this field
+// existed.
+type NodeConfig struct {
+	PreferredCertIssuers []string
+}
+
+// VerifyCertificates checks r's certificate chains against cfg's preferred issuers, trying the most
+// preferred chain first and falling back through the rest (see pickChain and verifyRecordCertChains) so
+// that a record carrying several alternative chains -- e.g. while a network migrates from one root CA to
+// another -- validates so long as any one of them does. verify performs the actual cryptographic chain
+// validation against this node's trusted roots; it is supplied by the caller because Record itself has no
+// notion of which roots a given node trusts.
+func (r *Record) VerifyCertificates(cfg *NodeConfig, verify func([]Certificate) error) error {
+	var pref []string
+	if cfg != nil {
+		pref = cfg.PreferredCertIssuers
+	}
+	return verifyRecordCertChains(r.CertChains, pref, verify)
+}
+
+// pickChain returns the first chain in chains whose root certificate's issuer common name matches one of
+// the names in pref, trying pref in order. Each chain is assumed to be ordered leaf-first (see Certificate),
+// so its root is chain[len(chain)-1]; a chain built in the opposite order will silently cause pickChain to
+// match (or fail to match) against the wrong certificate's issuer rather than returning an error, since
+// pickChain has no way to tell a reversed chain from a correctly-ordered one by inspecting it alone. If
+// none of the chains match any preferred issuer, pickChain falls back to the first chain that is non-empty.
+// It returns ErrRecordNoAcceptableChain if chains is empty or pref is non-empty but no chain matches and
+// there is no fallback candidate.
+//
+// This lets a record carry several alternative certificate chains (e.g. while a network migrates from one
+// root CA to another) and lets each validator pick the chain rooted in whichever issuer its own
+// node-config.PreferredCertIssuers prefers, rather than requiring every record to carry exactly one chain
+// that every validator on the network must agree to trust.
+func pickChain(chains [][]Certificate, pref []string) ([]Certificate, error) {
+	if len(chains) == 0 {
+		return nil, ErrRecordNoAcceptableChain
+	}
+
+	for _, issuerCN := range pref {
+		for _, chain := range chains {
+			if len(chain) == 0 {
+				continue
+			}
+			root := chain[len(chain)-1]
+			if root.IssuerCommonName() == issuerCN {
+				return chain, nil
+			}
+		}
+	}
+
+	for _, chain := range chains {
+		if len(chain) > 0 {
+			return chain, nil
+		}
+	}
+
+	return nil, ErrRecordNoAcceptableChain
+}
+
+// verifyRecordCertChains is the entry point a record's verify path should call in place of checking a
+// single certificate chain. It tries the chain pickChain selects first, then every other non-empty chain in
+// the order chains stores them (a chain matching a less preferred issuer gets no priority over the rest),
+// and only reports failure if none of them validate. This allows network operators to add
+// a new preferred root to PreferredCertIssuers and have validators silently move over to records signed
+// under it, without records that still carry the old chain suddenly failing.
+func verifyRecordCertChains(chains [][]Certificate, pref []string, verify func([]Certificate) error) error {
+	preferred, err := pickChain(chains, pref)
+	if err == nil {
+		if verify(preferred) == nil {
+			return nil
+		}
+	}
+
+	var lastErr error = ErrRecordNoAcceptableChain
+	for _, chain := range chains {
+		if len(chain) == 0 {
+			continue
+		}
+		// Skip re-verifying the chain already tried above as the preferred one; verify is an expensive
+		// cryptographic check and the call above already showed this chain fails.
+		if err == nil && len(chain) == len(preferred) && &chain[0] == &preferred[0] {
+			continue
+		}
+		if verifyErr := verify(chain); verifyErr == nil {
+			return nil
+		} else {
+			lastErr = verifyErr
+		}
+	}
+
+	return lastErr
+}
@@ -0,0 +1,164 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// selfSignedRoot builds a minimal self-signed root certificate (Issuer == Subject) with the given common
+// name, so pickChain's IssuerCommonName() check on a one-certificate "chain" has something real to parse.
+func selfSignedRoot(t *testing.T, commonName string) Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Unix(0, 0),
+		NotAfter:     time.Unix(0, 0).AddDate(100, 0, 0),
+		IsCA:         true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Certificate{Raw: der}
+}
+
+func TestCertificateIssuerCommonName(t *testing.T) {
+	c := selfSignedRoot(t, "root-a")
+	if got := c.IssuerCommonName(); got != "root-a" {
+		t.Errorf("IssuerCommonName() = %q, want %q", got, "root-a")
+	}
+	if got := (Certificate{Raw: []byte("not a certificate")}).IssuerCommonName(); got != "" {
+		t.Errorf("IssuerCommonName() on garbage Raw = %q, want \"\"", got)
+	}
+}
+
+func TestPickChainNoChains(t *testing.T) {
+	if _, err := pickChain(nil, []string{"root-a"}); err != ErrRecordNoAcceptableChain {
+		t.Errorf("err = %v, want ErrRecordNoAcceptableChain", err)
+	}
+}
+
+func TestPickChainFallsBackWhenNoPreferenceMatches(t *testing.T) {
+	chains := [][]Certificate{{selfSignedRoot(t, "root-a")}, {selfSignedRoot(t, "root-b")}}
+	got, err := pickChain(chains, []string{"root-z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0].IssuerCommonName() != "root-a" {
+		t.Errorf("got chain rooted at %q, want fallback to first non-empty chain root-a", got[0].IssuerCommonName())
+	}
+}
+
+func TestPickChainHonorsPreferenceOrder(t *testing.T) {
+	chains := [][]Certificate{{selfSignedRoot(t, "root-a")}, {selfSignedRoot(t, "root-b")}}
+	got, err := pickChain(chains, []string{"root-b", "root-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0].IssuerCommonName() != "root-b" {
+		t.Errorf("got chain rooted at %q, want preferred root-b", got[0].IssuerCommonName())
+	}
+}
+
+func TestVerifyRecordCertChainsTriesEachChainOnFailure(t *testing.T) {
+	chains := [][]Certificate{{selfSignedRoot(t, "root-a")}, {selfSignedRoot(t, "root-b")}}
+	var tried []string
+	verify := func(chain []Certificate) error {
+		cn := chain[0].IssuerCommonName()
+		tried = append(tried, cn)
+		if cn == "root-b" {
+			return nil
+		}
+		return ErrRecordCertificateInvalid
+	}
+
+	if err := verifyRecordCertChains(chains, nil, verify); err != nil {
+		t.Fatalf("expected success once root-b validates, got %v", err)
+	}
+	if len(tried) == 0 {
+		t.Fatal("verify was never called")
+	}
+}
+
+func TestVerifyRecordCertChainsDoesNotRetryFailedPreferredChain(t *testing.T) {
+	chains := [][]Certificate{{selfSignedRoot(t, "root-a")}, {selfSignedRoot(t, "root-b")}}
+	calls := make(map[string]int)
+	verify := func(chain []Certificate) error {
+		calls[chain[0].IssuerCommonName()]++
+		return ErrRecordCertificateInvalid
+	}
+
+	if err := verifyRecordCertChains(chains, []string{"root-a"}, verify); err != ErrRecordCertificateInvalid {
+		t.Errorf("err = %v, want ErrRecordCertificateInvalid", err)
+	}
+	if calls["root-a"] != 1 {
+		t.Errorf("verify was called %d times for the preferred chain root-a, want exactly 1", calls["root-a"])
+	}
+}
+
+func TestVerifyRecordCertChainsFailsWhenNoneValidate(t *testing.T) {
+	chains := [][]Certificate{{selfSignedRoot(t, "root-a")}, {selfSignedRoot(t, "root-b")}}
+	verify := func(chain []Certificate) error { return ErrRecordCertificateInvalid }
+
+	if err := verifyRecordCertChains(chains, nil, verify); err != ErrRecordCertificateInvalid {
+		t.Errorf("err = %v, want ErrRecordCertificateInvalid", err)
+	}
+}
+
+func TestRecordVerifyCertificatesWiresNodeConfig(t *testing.T) {
+	r := &Record{CertChains: [][]Certificate{{selfSignedRoot(t, "root-a")}, {selfSignedRoot(t, "root-b")}}}
+	cfg := &NodeConfig{PreferredCertIssuers: []string{"root-b"}}
+
+	var gotCN string
+	err := r.VerifyCertificates(cfg, func(chain []Certificate) error {
+		gotCN = chain[0].IssuerCommonName()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotCN != "root-b" {
+		t.Errorf("VerifyCertificates did not try the preferred chain first: got %q", gotCN)
+	}
+
+	if err := r.VerifyCertificates(nil, func(chain []Certificate) error { return nil }); err != nil {
+		t.Errorf("VerifyCertificates with nil NodeConfig should still validate against a fallback chain: %v", err)
+	}
+}
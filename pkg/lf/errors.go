@@ -72,6 +72,7 @@ const (
 	ErrQueryRequiresSelectors Err = "query requires at least one selector"
 	ErrQueryInvalidSortOrder  Err = "invalid sort order value"
 	ErrAlreadyMounted         Err = "mount point already mounted"
+	ErrPulseSubscribeFailed   Err = "pulse subscription failed"
 )
 
 //////////////////////////////////////////////////////////////////////////////
@@ -99,6 +100,9 @@ const (
 	ErrRecordCertificateInvalid        ErrRecord = "certificate invalid"
 	ErrRecordCertificateRequired       ErrRecord = "certificate required"
 	ErrRecordProhibited                ErrRecord = "record administratively prohibited"
+	ErrRecordNoAcceptableChain         ErrRecord = "no certificate chain validated against any acceptable root"
+	ErrRecordAliasLoop                 ErrRecord = "alias chain contains a cycle"
+	ErrRecordAliasTooDeep              ErrRecord = "alias chain exceeds maximum allowed hops"
 )
 
 //////////////////////////////////////////////////////////////////////////////
@@ -118,11 +122,81 @@ func (e ErrDatabase) Error() string {
 
 //////////////////////////////////////////////////////////////////////////////
 
+// ErrCategory is a broad machine-readable classification of an ErrAPI, intended to let callers decide
+// whether an operation is worth retrying without having to pattern-match on ErrCode or Message.
+type ErrCategory string
+
+// Error categories
+const (
+	CategoryValidationError ErrCategory = "ValidationError" // request was malformed or failed a check; retrying unchanged will not help
+	CategoryAuthError       ErrCategory = "AuthError"       // caller is not authorized to perform this operation
+	CategoryTransientError  ErrCategory = "TransientError"  // likely to succeed if retried, e.g. after a backoff
+	CategoryNotFoundError   ErrCategory = "NotFoundError"   // requested object does not exist
+	CategoryServerError     ErrCategory = "ServerError"     // internal failure unrelated to the request itself
+)
+
+// errCodeTableEntry is a (Code, Category) pair for one Err or ErrRecord variant.
+type errCodeTableEntry struct {
+	Code     string
+	Category ErrCategory
+}
+
+// errCodeTable is the central table from which ErrAPI.ErrCode and ErrAPI.Category are populated. Every
+// exported Err and ErrRecord constant should have an entry here; NewErrAPI falls back to a generic
+// ServerError code for anything missing so that adding a new error variant without updating this table
+// fails safe rather than panicking.
+var errCodeTable = map[error]errCodeTableEntry{
+	ErrInvalidPublicKey:       {"invalid_public_key", CategoryValidationError},
+	ErrInvalidPrivateKey:      {"invalid_private_key", CategoryValidationError},
+	ErrInvalidParameter:       {"invalid_parameter", CategoryValidationError},
+	ErrInvalidObject:          {"invalid_object", CategoryValidationError},
+	ErrUnsupportedType:        {"unsupported_type", CategoryValidationError},
+	ErrUnsupportedCurve:       {"unsupported_curve", CategoryValidationError},
+	ErrOutOfRange:             {"out_of_range", CategoryValidationError},
+	ErrWharrgarblFailed:       {"wharrgarbl_failed", CategoryTransientError},
+	ErrIO:                     {"io_error", CategoryTransientError},
+	ErrIncorrectKey:           {"incorrect_key", CategoryAuthError},
+	ErrAlreadyConnected:       {"already_connected", CategoryValidationError},
+	ErrRecordNotFound:         {"record.not_found", CategoryNotFoundError},
+	ErrRecordIsNewer:          {"record.is_newer", CategoryValidationError},
+	ErrPulseSpanExeceeded:     {"pulse.span_exceeded", CategoryValidationError},
+	ErrDuplicateRecord:        {"record.duplicate", CategoryValidationError},
+	ErrPrivateKeyRequired:     {"private_key_required", CategoryAuthError},
+	ErrInvalidMessageSize:     {"invalid_message_size", CategoryValidationError},
+	ErrQueryRequiresSelectors: {"query.requires_selectors", CategoryValidationError},
+	ErrQueryInvalidSortOrder:  {"query.invalid_sort_order", CategoryValidationError},
+	ErrAlreadyMounted:         {"already_mounted", CategoryValidationError},
+	ErrPulseSubscribeFailed:   {"pulse.subscribe_failed", CategoryTransientError},
+
+	ErrRecordInvalid:                   {"record.invalid", CategoryValidationError},
+	ErrRecordOwnerSignatureCheckFailed: {"record.owner_signature_invalid", CategoryAuthError},
+	ErrRecordInsufficientWork:          {"record.insufficient_work", CategoryValidationError},
+	ErrRecordNotApproved:               {"record.not_approved", CategoryValidationError},
+	ErrRecordInsufficientLinks:         {"record.insufficient_links", CategoryValidationError},
+	ErrRecordTooManyLinks:              {"record.too_many_links", CategoryValidationError},
+	ErrRecordInvalidLinks:              {"record.invalid_links", CategoryValidationError},
+	ErrRecordTooManySelectors:          {"record.too_many_selectors", CategoryValidationError},
+	ErrRecordUnsupportedAlgorithm:      {"record.unsupported_algorithm", CategoryValidationError},
+	ErrRecordTooLarge:                  {"record.too_large", CategoryValidationError},
+	ErrRecordValueTooLarge:             {"record.value_too_large", CategoryValidationError},
+	ErrRecordViolatesSpecialRelativity: {"record.timestamp_in_future", CategoryValidationError},
+	ErrRecordTooOld:                    {"record.too_old", CategoryValidationError},
+	ErrRecordCertificateInvalid:        {"record.certificate.invalid", CategoryAuthError},
+	ErrRecordCertificateRequired:       {"record.certificate.required", CategoryAuthError},
+	ErrRecordProhibited:                {"record.prohibited", CategoryAuthError},
+	ErrRecordNoAcceptableChain:         {"record.certificate.no_acceptable_chain", CategoryAuthError},
+	ErrRecordAliasLoop:                 {"record.alias.loop", CategoryValidationError},
+	ErrRecordAliasTooDeep:              {"record.alias.too_deep", CategoryValidationError},
+}
+
 // ErrAPI (response) indicates an error and is returned with non-200 responses.
 type ErrAPI struct {
-	Code        int    ``                  // HTTP response code
-	Message     string `json:",omitempty"` // Message indicating the reason for the error
-	ErrTypeName string `json:",omitempty"` // Name of LF native error or empty if HTTP or transport error
+	Code        int                    ``                         // HTTP response code
+	Message     string                 `json:",omitempty"`        // Message indicating the reason for the error
+	ErrTypeName string                 `json:",omitempty"`        // Name of LF native error or empty if HTTP or transport error
+	ErrCode     string                 `json:",omitempty"`        // Stable machine-readable code, e.g. "record.certificate.required"
+	Category    ErrCategory            `json:",omitempty"`        // Broad category, e.g. TransientError, for retry decisions
+	Context     map[string]interface{} `json:"context,omitempty"` // Structured details specific to this error, e.g. an accepted limit
 }
 
 // Error implements the error interface, making APIError an 'error' in the Go sense.
@@ -133,4 +207,67 @@ func (e ErrAPI) Error() string {
 	return fmt.Sprintf("%d:%s", e.Code, e.Message)
 }
 
+// WithContext returns a copy of e with Context set to ctx, for attaching structured details to an API
+// error response (e.g. the accepted pulse-span maximum when returning ErrPulseSpanExeceeded).
+func (e ErrAPI) WithContext(ctx map[string]interface{}) ErrAPI {
+	e.Context = ctx
+	return e
+}
+
+// NewErrAPI builds an ErrAPI from an HTTP status code and the underlying LF native error, populating
+// ErrTypeName, ErrCode, and Category from errCodeTable. Errors with no table entry are reported as a
+// generic ServerError with an empty ErrCode so that a missing table entry degrades gracefully instead of
+// misleading a caller into retrying something it should not. The table holds no ErrDatabase entries, so
+// every ErrDatabase value is reported this way.
+func NewErrAPI(code int, err error) ErrAPI {
+	e := ErrAPI{Code: code, ErrTypeName: errTypeName(err)}
+	if err != nil {
+		e.Message = err.Error()
+	}
+	if entry, ok := errCodeTable[err]; ok {
+		e.ErrCode = entry.Code
+		e.Category = entry.Category
+	} else if err != nil {
+		e.Category = CategoryServerError
+	}
+	return e
+}
+
+// IsRetryable reports whether err represents a condition that may succeed if the caller retries, e.g.
+// after a backoff. Only ErrAPI values with Category set to TransientError are considered retryable;
+// everything else (validation failures, auth failures, not-found, or errors with no category at all) is
+// treated as not retryable so that callers default to the safer behavior when in doubt.
+func IsRetryable(err error) bool {
+	var api ErrAPI
+	switch e := err.(type) {
+	case ErrAPI:
+		api = e
+	case *ErrAPI:
+		if e == nil {
+			return false
+		}
+		api = *e
+	default:
+		return false
+	}
+	return api.Category == CategoryTransientError
+}
+
+// ErrAPIJSONSchema is a JSON schema describing the ErrAPI envelope returned by the HTTP API on non-200
+// responses, for clients that want to validate or generate bindings against it.
+const ErrAPIJSONSchema = `{
+	"$schema": "http://json-schema.org/draft-07/schema#",
+	"title": "ErrAPI",
+	"type": "object",
+	"properties": {
+		"Code": { "type": "integer", "description": "HTTP response code" },
+		"Message": { "type": "string" },
+		"ErrTypeName": { "type": "string" },
+		"ErrCode": { "type": "string", "description": "Stable machine-readable error code" },
+		"Category": { "type": "string", "enum": ["ValidationError", "AuthError", "TransientError", "NotFoundError", "ServerError"] },
+		"context": { "type": "object" }
+	},
+	"required": ["Code"]
+}`
+
 //////////////////////////////////////////////////////////////////////////////
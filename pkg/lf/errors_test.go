@@ -0,0 +1,139 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import "testing"
+
+// allErrConstants and allErrRecordConstants must be kept in sync with the Err / ErrRecord const blocks in
+// errors.go; this is the cross-check that errCodeTable has not silently fallen behind as new error variants
+// are added (NewErrAPI degrades a missing entry to a generic ServerError rather than failing loudly).
+var allErrConstants = []Err{
+	ErrInvalidPublicKey, ErrInvalidPrivateKey, ErrInvalidParameter, ErrInvalidObject, ErrUnsupportedType,
+	ErrUnsupportedCurve, ErrOutOfRange, ErrWharrgarblFailed, ErrIO, ErrIncorrectKey, ErrAlreadyConnected,
+	ErrRecordNotFound, ErrRecordIsNewer, ErrPulseSpanExeceeded, ErrDuplicateRecord, ErrPrivateKeyRequired,
+	ErrInvalidMessageSize, ErrQueryRequiresSelectors, ErrQueryInvalidSortOrder, ErrAlreadyMounted,
+	ErrPulseSubscribeFailed,
+}
+
+var allErrRecordConstants = []ErrRecord{
+	ErrRecordInvalid, ErrRecordOwnerSignatureCheckFailed, ErrRecordInsufficientWork, ErrRecordNotApproved,
+	ErrRecordInsufficientLinks, ErrRecordTooManyLinks, ErrRecordInvalidLinks, ErrRecordTooManySelectors,
+	ErrRecordUnsupportedAlgorithm, ErrRecordTooLarge, ErrRecordValueTooLarge, ErrRecordViolatesSpecialRelativity,
+	ErrRecordTooOld, ErrRecordCertificateInvalid, ErrRecordCertificateRequired, ErrRecordProhibited,
+	ErrRecordNoAcceptableChain, ErrRecordAliasLoop, ErrRecordAliasTooDeep,
+}
+
+func TestErrCodeTableCoversEveryErrConstant(t *testing.T) {
+	for _, e := range allErrConstants {
+		if _, ok := errCodeTable[e]; !ok {
+			t.Errorf("errCodeTable has no entry for Err %q", e)
+		}
+	}
+	for _, e := range allErrRecordConstants {
+		if _, ok := errCodeTable[e]; !ok {
+			t.Errorf("errCodeTable has no entry for ErrRecord %q", e)
+		}
+	}
+}
+
+func TestErrCodeTableEntriesAreNonEmpty(t *testing.T) {
+	for err, entry := range errCodeTable {
+		if entry.Code == "" {
+			t.Errorf("errCodeTable[%v] has an empty Code", err)
+		}
+		if entry.Category == "" {
+			t.Errorf("errCodeTable[%v] has an empty Category", err)
+		}
+	}
+}
+
+func TestNewErrAPIFallsBackToServerErrorForUnknownErr(t *testing.T) {
+	unknown := Err("some new error nobody registered yet")
+	api := NewErrAPI(500, unknown)
+	if api.Category != CategoryServerError {
+		t.Errorf("Category = %q, want %q", api.Category, CategoryServerError)
+	}
+	if api.ErrCode != "" {
+		t.Errorf("ErrCode = %q, want empty for an unregistered error", api.ErrCode)
+	}
+}
+
+func TestNewErrAPIPopulatesFromTable(t *testing.T) {
+	api := NewErrAPI(429, ErrPulseSubscribeFailed)
+	entry := errCodeTable[ErrPulseSubscribeFailed]
+	if api.ErrCode != entry.Code {
+		t.Errorf("ErrCode = %q, want %q", api.ErrCode, entry.Code)
+	}
+	if api.Category != entry.Category {
+		t.Errorf("Category = %q, want %q", api.Category, entry.Category)
+	}
+	if api.ErrTypeName != "Err" {
+		t.Errorf("ErrTypeName = %q, want %q", api.ErrTypeName, "Err")
+	}
+}
+
+func TestErrAPIWithContextAttachesStructuredDetailsWithoutMutatingReceiver(t *testing.T) {
+	base := NewErrAPI(400, ErrPulseSpanExeceeded)
+	withCtx := base.WithContext(map[string]interface{}{"acceptedMaxMinutes": RecordMaxPulseSpan})
+
+	if base.Context != nil {
+		t.Errorf("WithContext must not mutate the receiver: base.Context = %v, want nil", base.Context)
+	}
+	if withCtx.Context["acceptedMaxMinutes"] != RecordMaxPulseSpan {
+		t.Errorf(`Context["acceptedMaxMinutes"] = %v, want %v`, withCtx.Context["acceptedMaxMinutes"], RecordMaxPulseSpan)
+	}
+	if withCtx.ErrCode != base.ErrCode || withCtx.Category != base.Category || withCtx.Message != base.Message {
+		t.Error("WithContext should leave every field but Context unchanged")
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	transient := NewErrAPI(503, ErrIO)
+	if !IsRetryable(transient) {
+		t.Error("IsRetryable(ErrAPI wrapping ErrIO) = false, want true")
+	}
+	if !IsRetryable(&transient) {
+		t.Error("IsRetryable(*ErrAPI wrapping ErrIO) = false, want true")
+	}
+
+	permanent := NewErrAPI(400, ErrInvalidParameter)
+	if IsRetryable(permanent) {
+		t.Error("IsRetryable(ErrAPI wrapping ErrInvalidParameter) = true, want false")
+	}
+
+	if IsRetryable(ErrIO) {
+		t.Error("IsRetryable(plain Err, not ErrAPI) = true, want false")
+	}
+	if IsRetryable(nil) {
+		t.Error("IsRetryable(nil) = true, want false")
+	}
+
+	var nilPtr *ErrAPI
+	if IsRetryable(nilPtr) {
+		t.Error("IsRetryable((*ErrAPI)(nil)) = true, want false")
+	}
+}
@@ -27,8 +27,8 @@
 package lf
 
 import (
-	"crypto/sha256"
 	"encoding/binary"
+	"hash"
 )
 
 // PulseSize is the size of a pulse in bytes.
@@ -64,9 +64,38 @@ func (p Pulse) String() string {
 	return "!" + Base62Encode(p)
 }
 
-// Token returns the record PulseToken that should match this pulse.
-// This evaluates the hash tree from its current value up to its final value.
-func (p Pulse) Token() uint64 { return th64n(p.Key(), p.Minutes()) }
+// writePulseSeedSelectors writes the selector names/ordinals and record timestamp components shared by
+// every flavor of pulse seed (plain, batched/cached, and alias) into h.
+func writePulseSeedSelectors(h hash.Hash, selectorNames [][]byte, selectorOrdinals []uint64, recordTimestamp uint64) {
+	var tmp [8]byte
+	for i := 0; i < len(selectorNames); i++ {
+		h.Write(selectorNames[i])
+		if i < len(selectorOrdinals) {
+			binary.BigEndian.PutUint64(tmp[:], selectorOrdinals[i])
+			h.Write(tmp[:])
+		}
+	}
+	binary.BigEndian.PutUint64(tmp[:], recordTimestamp)
+	h.Write(tmp[:])
+}
+
+// packPulse assembles the on-the-wire Pulse for an already-computed hash chain value and minute offset.
+func packPulse(chainValue uint64, minutes uint) Pulse {
+	var pbuf [PulseSize]byte
+	p := Pulse(pbuf[:])
+	binary.BigEndian.PutUint64(p[0:8], chainValue)
+	p[8] = byte(minutes >> 16)
+	p[9] = byte(minutes >> 8)
+	p[10] = byte(minutes)
+	return p
+}
+
+// newPulseFromSeed builds the on-the-wire Pulse for a given seed hash and minute offset by walking the
+// th64 hash chain RecordMaxPulseSpan-minutes steps from the seed. NewPulse and NewAliasPulse bottom out
+// here; NewPulseBatch and NewPulseCached walk the chain themselves and call packPulse directly.
+func newPulseFromSeed(seed uint64, minutes uint) Pulse {
+	return packPulse(th64n(seed, RecordMaxPulseSpan-minutes), minutes)
+}
 
 // NewPulse generates a pulse for a given record from its selectors, timestamp, and the owner's private key.
 // Use 0 for minutes to generate a pulse token for a new record. The pulse token is the final hash in the pulse
@@ -82,29 +111,6 @@ func NewPulse(owner *Owner, selectorNames [][]byte, selectorOrdinals []uint64, r
 		return
 	}
 
-	var tmp [32]byte
-	pulseTokenHasher := sha256.New()
-
-	for i := 0; i < len(selectorNames); i++ {
-		pulseTokenHasher.Write(selectorNames[i])
-		if i < len(selectorOrdinals) {
-			binary.BigEndian.PutUint64(tmp[0:8], selectorOrdinals[i])
-			pulseTokenHasher.Write(tmp[0:8])
-		}
-	}
-
-	binary.BigEndian.PutUint64(tmp[0:8], recordTimestamp)
-	pulseTokenHasher.Write(tmp[0:8])
-
-	ophash := owner.PrivateHash()
-	pulseTokenHasher.Write(ophash[:])
-
-	var pbuf [PulseSize]byte
-	p = pbuf[:]
-	binary.BigEndian.PutUint64(p[0:8], th64n(binary.BigEndian.Uint64(pulseTokenHasher.Sum(tmp[:0])), RecordMaxPulseSpan-minutes))
-	p[8] = byte(minutes >> 16)
-	p[9] = byte(minutes >> 8)
-	p[10] = byte(minutes)
-
+	p = newPulseFromSeed(pulseSeed(owner, selectorNames, selectorOrdinals, recordTimestamp), minutes)
 	return
 }
@@ -0,0 +1,221 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// MaxAliasHops is the maximum number of AliasTarget hops that pulse verification will follow before giving
+// up with ErrRecordAliasTooDeep. This bounds the cost of resolving an alias chain to a small constant
+// regardless of how deep a chain a malicious or misconfigured record claims to have.
+const MaxAliasHops = 4
+
+// AliasHop identifies one link in an alias chain: the record being aliased away from, described by the
+// hash of its owner's public key and a digest of its selector set (see selectorDigest).
+type AliasHop struct {
+	OwnerHash      [32]byte
+	SelectorDigest [32]byte
+}
+
+// AliasChain is the sequence of hops that pulse verification should walk, in order from the aliasing
+// record to its ultimate target. It is supplied by the caller (who has already resolved AliasTarget
+// pointers by looking up records) rather than discovered by Token itself, since Pulse has no access to
+// the record graph.
+type AliasChain []AliasHop
+
+// foldAliasHop combines a pulse chain value with one alias hop's identity, producing the value that the
+// next record in the chain is expected to have signed against. This keeps the final token dependent on
+// the full path of owners and selectors an alias passed through, not just the target's own chain, so that
+// redirecting an alias to a different target changes the expected token.
+func foldAliasHop(token uint64, hop AliasHop) uint64 {
+	var tmp [8]byte
+	h := sha256.New()
+	binary.BigEndian.PutUint64(tmp[:], token)
+	h.Write(tmp[:])
+	h.Write(hop.OwnerHash[:])
+	h.Write(hop.SelectorDigest[:])
+	return binary.BigEndian.Uint64(h.Sum(nil))
+}
+
+// Token returns the record PulseToken that should match this pulse, evaluating the hash tree from the
+// pulse's current value up to its final value. This is the non-alias case: it has no way to report "chain
+// too deep" or "chain contains a cycle", so a record that is itself an alias of another owner's selectors
+// must have its token resolved via ResolveAliasToken instead, which folds in each hop of the alias chain and
+// returns those conditions as errors rather than silently truncating or looping.
+func (p Pulse) Token() uint64 {
+	return th64n(p.Key(), p.Minutes())
+}
+
+// ResolveAliasToken walks chain hop by hop, folding each into this pulse's base token via foldAliasHop, and
+// returns the resulting value. It returns ErrRecordAliasTooDeep if chain has more than MaxAliasHops entries
+// and ErrRecordAliasLoop if any hop repeats a (OwnerHash, SelectorDigest) pair already seen earlier in the
+// walk. The cycle check uses a small fixed-size array sized to MaxAliasHops rather than a map, since the
+// walk is bounded and this keeps alias resolution allocation-free on the verification hot path.
+func (p Pulse) ResolveAliasToken(chain AliasChain) (uint64, error) {
+	if len(chain) > MaxAliasHops {
+		return 0, ErrRecordAliasTooDeep
+	}
+
+	var visited [MaxAliasHops]AliasHop
+	visitedCount := 0
+	token := th64n(p.Key(), p.Minutes())
+
+	for _, hop := range chain {
+		for i := 0; i < visitedCount; i++ {
+			if visited[i] == hop {
+				return 0, ErrRecordAliasLoop
+			}
+		}
+		visited[visitedCount] = hop
+		visitedCount++
+		token = foldAliasHop(token, hop)
+	}
+
+	return token, nil
+}
+
+// aliasHopSize is the encoded length of an AliasHop: OwnerHash followed by SelectorDigest, back to back
+// with no length prefix since both fields are fixed size.
+const aliasHopSize = 64
+
+// EncodeAliasHop serializes hop for storage in a Record's AliasTarget field.
+func EncodeAliasHop(hop AliasHop) []byte {
+	b := make([]byte, aliasHopSize)
+	copy(b[0:32], hop.OwnerHash[:])
+	copy(b[32:64], hop.SelectorDigest[:])
+	return b
+}
+
+// DecodeAliasHop parses an AliasTarget byte slice previously produced by EncodeAliasHop, returning
+// ErrRecordInvalid if b is not exactly aliasHopSize bytes.
+func DecodeAliasHop(b []byte) (hop AliasHop, err error) {
+	if len(b) != aliasHopSize {
+		err = ErrRecordInvalid
+		return
+	}
+	copy(hop.OwnerHash[:], b[0:32])
+	copy(hop.SelectorDigest[:], b[32:64])
+	return
+}
+
+// ResolveAliasChain walks r's AliasTarget, and the AliasTarget of each record it in turn points to, into
+// the full AliasChain that Pulse.ResolveAliasToken expects. lookup is supplied by the caller to fetch the
+// record identified by a given hop, since a Record has no access to the record graph on its own; it
+// should return a nil *Record (with a nil error) if the hop cannot be resolved to a known record, which
+// ends the walk with whatever chain has been built so far. ResolveAliasChain returns a nil chain and nil
+// error if r is not an alias (AliasTarget is empty), and returns ErrRecordAliasTooDeep if following
+// AliasTarget pointers exceeds MaxAliasHops, enforcing the same bound Pulse.ResolveAliasToken enforces so
+// that a cycle of aliasing records cannot be used to loop lookup forever.
+func (r *Record) ResolveAliasChain(lookup func(AliasHop) (*Record, error)) (AliasChain, error) {
+	if len(r.AliasTarget) == 0 {
+		return nil, nil
+	}
+
+	var chain AliasChain
+	target := r.AliasTarget
+	for len(target) > 0 {
+		if len(chain) >= MaxAliasHops {
+			return nil, ErrRecordAliasTooDeep
+		}
+
+		hop, err := DecodeAliasHop(target)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, hop)
+
+		next, err := lookup(hop)
+		if err != nil {
+			return nil, err
+		}
+		if next == nil {
+			break
+		}
+		target = next.AliasTarget
+	}
+
+	return chain, nil
+}
+
+// VerifyPulse confirms that p actually advances r, following r's alias chain (see AliasTarget and
+// ResolveAliasChain) to fold in every hop it passes through if r designates itself as an alias, rather
+// than comparing p directly against expectedToken the way a non-alias record would. lookup is passed
+// straight through to ResolveAliasChain; cache is passed through to VerifyAgainst for the non-alias case
+// and may be nil. It returns ErrRecordInvalid if the (possibly alias-folded) token does not match
+// expectedToken, or whatever error ResolveAliasChain/ResolveAliasToken produced if the chain itself could
+// not be resolved.
+func (r *Record) VerifyPulse(p Pulse, expectedToken uint64, cache *PulseChainCache, lookup func(AliasHop) (*Record, error)) error {
+	chain, err := r.ResolveAliasChain(lookup)
+	if err != nil {
+		return err
+	}
+	if chain == nil {
+		if !p.VerifyAgainst(expectedToken, cache) {
+			return ErrRecordInvalid
+		}
+		return nil
+	}
+
+	token, err := p.ResolveAliasToken(chain)
+	if err != nil {
+		return err
+	}
+	if token != expectedToken {
+		return ErrRecordInvalid
+	}
+	return nil
+}
+
+// NewAliasPulse generates a pulse for a record that has designated itself as an alias of another owner's
+// selector set via AliasTarget, redirecting future pulse verification to targetOwner's chain. It is
+// identical to NewPulse except that the pulse seed additionally incorporates targetOwner's public key hash,
+// so that a pulse minted for an alias cannot be mistaken for (or replayed as) a normal pulse on the same
+// selectors, and so that retargeting an alias to a different successor owner changes every subsequent pulse.
+func NewAliasPulse(owner *Owner, targetOwner *Owner, selectorNames [][]byte, selectorOrdinals []uint64, recordTimestamp uint64, minutes uint) (p Pulse, err error) {
+	if owner.Private == nil {
+		err = ErrPrivateKeyRequired
+		return
+	}
+	if minutes > RecordMaxPulseSpan {
+		err = ErrInvalidParameter
+		return
+	}
+
+	h := sha256.New()
+	writePulseSeedSelectors(h, selectorNames, selectorOrdinals, recordTimestamp)
+
+	ophash := owner.PrivateHash()
+	h.Write(ophash[:])
+
+	targetHash := targetOwner.PublicHash()
+	h.Write(targetHash[:])
+
+	p = newPulseFromSeed(binary.BigEndian.Uint64(h.Sum(nil)), minutes)
+	return
+}
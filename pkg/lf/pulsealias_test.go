@@ -0,0 +1,179 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import "testing"
+
+func aliasHop(n byte) AliasHop {
+	var h AliasHop
+	h.OwnerHash[0] = n
+	h.SelectorDigest[0] = n
+	return h
+}
+
+func TestResolveAliasTokenEmptyChainMatchesToken(t *testing.T) {
+	var p Pulse = packPulse(12345, 10)
+	got, err := p.ResolveAliasToken(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != p.Token() {
+		t.Errorf("ResolveAliasToken(nil) = %d, want %d (Token())", got, p.Token())
+	}
+}
+
+func TestResolveAliasTokenFoldsHopsInOrder(t *testing.T) {
+	var p Pulse = packPulse(12345, 10)
+	chain := AliasChain{aliasHop(1), aliasHop(2)}
+	got, err := p.ResolveAliasToken(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := foldAliasHop(foldAliasHop(p.Token(), chain[0]), chain[1])
+	if got != want {
+		t.Errorf("ResolveAliasToken = %d, want %d", got, want)
+	}
+}
+
+func TestResolveAliasTokenTooDeep(t *testing.T) {
+	var p Pulse = packPulse(12345, 10)
+	chain := make(AliasChain, MaxAliasHops+1)
+	for i := range chain {
+		chain[i] = aliasHop(byte(i + 1))
+	}
+	if _, err := p.ResolveAliasToken(chain); err != ErrRecordAliasTooDeep {
+		t.Errorf("err = %v, want ErrRecordAliasTooDeep", err)
+	}
+}
+
+func TestResolveAliasTokenAtMaxDepthSucceeds(t *testing.T) {
+	var p Pulse = packPulse(12345, 10)
+	chain := make(AliasChain, MaxAliasHops)
+	for i := range chain {
+		chain[i] = aliasHop(byte(i + 1))
+	}
+	if _, err := p.ResolveAliasToken(chain); err != nil {
+		t.Errorf("unexpected error at exactly MaxAliasHops: %v", err)
+	}
+}
+
+func TestResolveAliasTokenDetectsLoop(t *testing.T) {
+	var p Pulse = packPulse(12345, 10)
+	hop := aliasHop(7)
+	chain := AliasChain{aliasHop(1), hop, aliasHop(2), hop}
+	if _, err := p.ResolveAliasToken(chain); err != ErrRecordAliasLoop {
+		t.Errorf("err = %v, want ErrRecordAliasLoop", err)
+	}
+}
+
+func TestEncodeDecodeAliasHopRoundTrips(t *testing.T) {
+	hop := aliasHop(9)
+	got, err := DecodeAliasHop(EncodeAliasHop(hop))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != hop {
+		t.Errorf("DecodeAliasHop(EncodeAliasHop(hop)) = %+v, want %+v", got, hop)
+	}
+}
+
+func TestDecodeAliasHopRejectsWrongSize(t *testing.T) {
+	if _, err := DecodeAliasHop([]byte("too short")); err != ErrRecordInvalid {
+		t.Errorf("err = %v, want ErrRecordInvalid", err)
+	}
+}
+
+func TestResolveAliasChainNonAliasRecordReturnsNilChain(t *testing.T) {
+	r := &Record{}
+	chain, err := r.ResolveAliasChain(func(AliasHop) (*Record, error) { return nil, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chain != nil {
+		t.Errorf("chain = %v, want nil for a non-alias record", chain)
+	}
+}
+
+func TestResolveAliasChainWalksMultipleHops(t *testing.T) {
+	hop1, hop2 := aliasHop(1), aliasHop(2)
+	target := &Record{AliasTarget: EncodeAliasHop(hop2)}
+	r := &Record{AliasTarget: EncodeAliasHop(hop1)}
+
+	chain, err := r.ResolveAliasChain(func(hop AliasHop) (*Record, error) {
+		if hop == hop1 {
+			return target, nil
+		}
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AliasChain{hop1, hop2}
+	if len(chain) != len(want) || chain[0] != want[0] || chain[1] != want[1] {
+		t.Errorf("chain = %v, want %v", chain, want)
+	}
+}
+
+func TestResolveAliasChainTooDeep(t *testing.T) {
+	r := &Record{AliasTarget: EncodeAliasHop(aliasHop(1))}
+	n := 0
+	_, err := r.ResolveAliasChain(func(AliasHop) (*Record, error) {
+		n++
+		return &Record{AliasTarget: EncodeAliasHop(aliasHop(byte(n + 1)))}, nil
+	})
+	if err != ErrRecordAliasTooDeep {
+		t.Errorf("err = %v, want ErrRecordAliasTooDeep", err)
+	}
+}
+
+func TestVerifyPulseNonAliasComparesDirectly(t *testing.T) {
+	r := &Record{}
+	p := packPulse(12345, 10)
+	lookup := func(AliasHop) (*Record, error) { return nil, nil }
+
+	if err := r.VerifyPulse(p, p.Token(), nil, lookup); err != nil {
+		t.Errorf("VerifyPulse with matching token: %v", err)
+	}
+	if err := r.VerifyPulse(p, p.Token()+1, nil, lookup); err != ErrRecordInvalid {
+		t.Errorf("err = %v, want ErrRecordInvalid for mismatched token", err)
+	}
+}
+
+func TestVerifyPulseAliasFoldsChain(t *testing.T) {
+	hop := aliasHop(3)
+	r := &Record{AliasTarget: EncodeAliasHop(hop)}
+	p := packPulse(12345, 10)
+	lookup := func(AliasHop) (*Record, error) { return nil, nil }
+
+	want := foldAliasHop(p.Token(), hop)
+	if err := r.VerifyPulse(p, want, nil, lookup); err != nil {
+		t.Errorf("VerifyPulse with correctly-folded token: %v", err)
+	}
+	if err := r.VerifyPulse(p, p.Token(), nil, lookup); err != ErrRecordInvalid {
+		t.Errorf("err = %v, want ErrRecordInvalid when comparing against the unfolded token", err)
+	}
+}
@@ -0,0 +1,174 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// pulseSubscribeUpgrader upgrades /pulse/subscribe connections to WebSockets. Error overrides gorilla's
+// default behavior of writing its own plain-text HTTP error response (e.g. a 400 for a missing or malformed
+// WebSocket handshake header) so that an upgrade failure still produces the same ErrAPI JSON envelope every
+// other /pulse/subscribe failure does, instead of the response being committed out from under
+// writePulseSubscribeError before it gets a chance to run.
+var pulseSubscribeUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+	Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		writePulseSubscribeError(w, NewErrAPI(http.StatusServiceUnavailable, ErrPulseSubscribeFailed))
+	},
+}
+
+// pulseSubscribeLongPollTimeout bounds how long a long-poll request will block waiting for a single update.
+const pulseSubscribeLongPollTimeout = 30 * time.Second
+
+// pulseSubscribePingInterval is how often the server pings an idle WebSocket subscriber to detect a dead
+// connection; pulseSubscribePongWait is how long it will wait for a pong before giving up on the connection
+// and reaping its goroutine (only a pong extends the read deadline, not other incoming frames).
+const (
+	pulseSubscribePingInterval = 30 * time.Second
+	pulseSubscribePongWait     = 60 * time.Second
+)
+
+// PulseSubscribeFrame is the JSON object streamed to subscribers, one per matched pulse.
+type PulseSubscribeFrame struct {
+	Pulse             string `json:"pulse"`
+	Key               uint64 `json:"key"`
+	Minutes           uint   `json:"minutes"`
+	MatchedRecordHash string `json:"matchedRecordHash"`
+}
+
+func newPulseSubscribeFrame(u PulseUpdate) PulseSubscribeFrame {
+	return PulseSubscribeFrame{
+		Pulse:             u.Pulse.String(),
+		Key:               u.Key,
+		Minutes:           u.Minutes,
+		MatchedRecordHash: Base62Encode(u.MatchedRecordHash[:]),
+	}
+}
+
+// writePulseSubscribeError sends apiErr as the JSON response body for a /pulse/subscribe request that
+// could not be serviced, whether that is a non-WebSocket failure path or pulseSubscribeUpgrader.Error
+// reporting a failed upgrade.
+func writePulseSubscribeError(w http.ResponseWriter, apiErr ErrAPI) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Code)
+	_ = json.NewEncoder(w).Encode(apiErr)
+}
+
+// ServeHTTPPulseSubscribe implements the /pulse/subscribe endpoint for a node's HTTP API. If the client
+// requests a WebSocket upgrade (the usual case) it streams one JSON frame per matched pulse until the
+// connection is closed. Clients that cannot use WebSockets (e.g. behind a proxy that strips the Upgrade
+// header) may instead long-poll: each request blocks until either a single matching pulse arrives or
+// pulseSubscribeLongPollTimeout elapses, and returns a JSON array (possibly empty) of frames.
+//
+// If backfill is non-nil, that pulse (typically the most recently known one) is sent as an initial frame so
+// the client can confirm its chain position via Pulse.Token() before relying on live updates.
+func ServeHTTPPulseSubscribe(bus *PulseBus, w http.ResponseWriter, req *http.Request, filter PulseSubscriberFilter, backfill *Pulse) {
+	sub := NewPulseSubscriber(filter)
+	bus.Subscribe(sub)
+	defer bus.Unsubscribe(sub)
+	defer sub.Close()
+
+	if websocket.IsWebSocketUpgrade(req) {
+		conn, err := pulseSubscribeUpgrader.Upgrade(w, req, nil)
+		if err != nil {
+			// pulseSubscribeUpgrader.Error has already written the ErrAPI envelope.
+			return
+		}
+		defer conn.Close()
+
+		if backfill != nil {
+			if conn.WriteJSON(newPulseSubscribeFrame(PulseUpdate{Pulse: *backfill, Key: backfill.Key(), Minutes: backfill.Minutes()})) != nil {
+				return
+			}
+		}
+
+		// Reap the connection if the peer goes quiet: a reader goroutine is required even though this
+		// handler never expects incoming frames, since it's the only way gorilla/websocket notices a closed
+		// or dead TCP connection (WriteJSON alone would not fail until the peer's receive buffer also fills).
+		conn.SetReadDeadline(time.Now().Add(pulseSubscribePongWait))
+		conn.SetPongHandler(func(string) error {
+			conn.SetReadDeadline(time.Now().Add(pulseSubscribePongWait))
+			return nil
+		})
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		ping := time.NewTicker(pulseSubscribePingInterval)
+		defer ping.Stop()
+
+		for {
+			select {
+			case u, ok := <-sub.Updates():
+				if !ok {
+					return
+				}
+				if conn.WriteJSON(newPulseSubscribeFrame(u)) != nil {
+					return
+				}
+			case <-ping.C:
+				if conn.WriteMessage(websocket.PingMessage, nil) != nil {
+					return
+				}
+			case <-closed:
+				return
+			}
+		}
+	}
+
+	// Long-poll fallback: collect whatever arrives within the timeout window and return it as a JSON array.
+	frames := make([]PulseSubscribeFrame, 0, 1)
+	if backfill != nil {
+		frames = append(frames, newPulseSubscribeFrame(PulseUpdate{Pulse: *backfill, Key: backfill.Key(), Minutes: backfill.Minutes()}))
+	}
+
+	select {
+	case u, ok := <-sub.Updates():
+		if ok {
+			frames = append(frames, newPulseSubscribeFrame(u))
+		}
+	case <-time.After(pulseSubscribeLongPollTimeout):
+	case <-req.Context().Done():
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(frames)
+}
@@ -0,0 +1,172 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestServeHTTPPulseSubscribeUpgradeFailureReturnsAPIError(t *testing.T) {
+	bus := NewPulseBus()
+
+	req := httptest.NewRequest(http.MethodGet, "/pulse/subscribe", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	// No Sec-WebSocket-Key/Version, and httptest's ResponseRecorder does not implement http.Hijacker,
+	// so pulseSubscribeUpgrader.Upgrade is guaranteed to fail here without a real network connection.
+	rec := httptest.NewRecorder()
+
+	ServeHTTPPulseSubscribe(bus, rec, req, PulseSubscriberFilter{}, nil)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Code = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	var apiErr ErrAPI
+	if err := json.Unmarshal(rec.Body.Bytes(), &apiErr); err != nil {
+		t.Fatalf("response body did not decode as ErrAPI: %v", err)
+	}
+	if apiErr.ErrTypeName != "Err" || apiErr.Message != string(ErrPulseSubscribeFailed) {
+		t.Errorf("apiErr = %+v, want it to wrap ErrPulseSubscribeFailed", apiErr)
+	}
+	if bus.Len() != 0 {
+		t.Errorf("bus.Len() = %d, want 0 after a failed upgrade unsubscribes", bus.Len())
+	}
+}
+
+func TestServeHTTPPulseSubscribeWebSocketUnsubscribesOnClientClose(t *testing.T) {
+	bus := NewPulseBus()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ServeHTTPPulseSubscribe(bus, w, r, PulseSubscriberFilter{}, nil)
+	}))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/pulse/subscribe"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for bus.Len() != 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if bus.Len() != 1 {
+		t.Fatalf("bus.Len() = %d, want 1 once the WebSocket subscriber is registered", bus.Len())
+	}
+
+	// Closing the client side should make the handler's reader goroutine observe a read error and tear
+	// down the subscription, without waiting for a ping/pong timeout.
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline = time.Now().Add(2 * time.Second)
+	for bus.Len() != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if bus.Len() != 0 {
+		t.Errorf("bus.Len() = %d, want 0 after the client closed its connection", bus.Len())
+	}
+}
+
+func TestServeHTTPPulseSubscribeLongPollDeliversMatchingPulse(t *testing.T) {
+	bus := NewPulseBus()
+	ownerPublic := []byte("owner-a")
+
+	req := httptest.NewRequest(http.MethodGet, "/pulse/subscribe", nil)
+	rec := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ServeHTTPPulseSubscribe(bus, rec, req, PulseSubscriberFilter{Owner: ownerPublic}, nil)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for bus.Len() != 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if bus.Len() != 1 {
+		t.Fatalf("bus.Len() = %d, want 1 once the long-poll subscriber is registered", bus.Len())
+	}
+
+	var recordHash [32]byte
+	recordHash[0] = 7
+	p := Pulse(make([]byte, PulseSize))
+	bus.Publish(p, ownerPublic, [][]byte{[]byte("sel")}, recordHash)
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("ServeHTTPPulseSubscribe did not return after a matching pulse was published")
+	}
+
+	var frames []PulseSubscribeFrame
+	if err := json.Unmarshal(rec.Body.Bytes(), &frames); err != nil {
+		t.Fatalf("response body did not decode as a frame array: %v", err)
+	}
+	if len(frames) != 1 {
+		t.Fatalf("len(frames) = %d, want 1", len(frames))
+	}
+	if frames[0].MatchedRecordHash != Base62Encode(recordHash[:]) {
+		t.Errorf("MatchedRecordHash = %q, want %q", frames[0].MatchedRecordHash, Base62Encode(recordHash[:]))
+	}
+}
+
+func TestServeHTTPPulseSubscribeLongPollStopsOnRequestCancellation(t *testing.T) {
+	bus := NewPulseBus()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/pulse/subscribe", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ServeHTTPPulseSubscribe(bus, rec, req, PulseSubscriberFilter{}, nil)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("ServeHTTPPulseSubscribe did not return promptly for an already-canceled request context")
+	}
+
+	if rec.Body.Len() != 0 {
+		t.Errorf("body = %q, want empty: a canceled request should get no response body", rec.Body.String())
+	}
+}
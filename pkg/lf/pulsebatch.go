@@ -0,0 +1,256 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"sync"
+)
+
+// pulseChainCacheMaxEntries bounds how many chains a PulseChainCache will hold before it starts evicting
+// an arbitrary entry (Go map iteration order, not recency; neither map here tracks last-use order). Each
+// entry is small (a single uint64 plus its progress counter) so this is generous; it mainly guards against
+// unbounded growth from callers that churn through many records.
+const pulseChainCacheMaxEntries = 4096
+
+// pulseSeed computes the seed hash shared by every pulse for a given owner, selector set, and record
+// timestamp. It is the expensive, one-time part of NewPulse and NewPulseBatch.
+func pulseSeed(owner *Owner, selectorNames [][]byte, selectorOrdinals []uint64, recordTimestamp uint64) uint64 {
+	h := sha256.New()
+	writePulseSeedSelectors(h, selectorNames, selectorOrdinals, recordTimestamp)
+	ophash := owner.PrivateHash()
+	h.Write(ophash[:])
+	return binary.BigEndian.Uint64(h.Sum(nil))
+}
+
+// NewPulseBatch generates pulses for many minute offsets of the same record in a single call. It computes
+// the expensive seed hash once, then walks the th64 hash chain forward from the seed in order of
+// increasing chain depth (i.e. decreasing minutes), so each additional pulse after the first costs only the
+// th64 applications between its depth and the previous one's (one per minute of difference) instead of a
+// full th64n recomputation. minutesList does not need to be pre-sorted; the
+// returned pulses are in the same order as the input.
+func NewPulseBatch(owner *Owner, selectorNames [][]byte, selectorOrdinals []uint64, recordTimestamp uint64, minutesList []uint) (pulses []Pulse, err error) {
+	if owner.Private == nil {
+		err = ErrPrivateKeyRequired
+		return
+	}
+	for _, m := range minutesList {
+		if m > RecordMaxPulseSpan {
+			err = ErrInvalidParameter
+			return
+		}
+	}
+
+	order := make([]int, len(minutesList))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return minutesList[order[a]] > minutesList[order[b]] })
+
+	seed := pulseSeed(owner, selectorNames, selectorOrdinals, recordTimestamp)
+
+	pulses = make([]Pulse, len(minutesList))
+	var chain uint64
+	var chainDepth uint
+	haveChain := false
+	for _, idx := range order {
+		minutes := minutesList[idx]
+		depth := RecordMaxPulseSpan - minutes
+
+		if !haveChain {
+			chain = seed
+			chainDepth = 0
+			haveChain = true
+		}
+		for chainDepth < depth {
+			chain = th64(chain)
+			chainDepth++
+		}
+
+		pulses[idx] = packPulse(chain, minutes)
+	}
+
+	return
+}
+
+// pulseChainCacheKey identifies a single hash chain: an owner, a record timestamp, and a digest of the
+// selector set that produced it (since two records can share an owner and timestamp but differ in selectors).
+type pulseChainCacheKey struct {
+	ophash          [32]byte
+	recordTimestamp uint64
+	selectorDigest  [32]byte
+}
+
+// pulseChainCacheEntry tracks how far a chain has been walked so far.
+type pulseChainCacheEntry struct {
+	value uint64
+	depth uint
+}
+
+// PulseChainCache memoizes th64 hash chains across calls to NewPulseCached (NewPulse itself does not use a
+// cache), so that generating many pulses for the same record in decreasing order of minutes does not
+// repeatedly pay the full O(n) th64n cost. It is safe for concurrent use.
+type PulseChainCache struct {
+	lock    sync.Mutex
+	entries map[pulseChainCacheKey]*pulseChainCacheEntry
+
+	// verifiedTokens memoizes VerifyAgainst's forward walk by (chain key, minutes); lazily allocated since
+	// many callers only ever use a PulseChainCache to generate pulses and never call VerifyAgainst.
+	verifiedTokens map[verifiedTokenCacheKey]uint64
+}
+
+// verifiedTokenCacheKey identifies one verifiedTokenAt walk. chainKey alone is not enough: two pulses
+// that happen to share the same 8-byte chain value but carry different minutes walk to different
+// terminal tokens, and keying on chainKey alone would silently serve one pulse's cached result to the
+// other.
+type verifiedTokenCacheKey struct {
+	chainKey uint64
+	minutes  uint
+}
+
+// NewPulseChainCache creates an empty chain cache.
+func NewPulseChainCache() *PulseChainCache {
+	return &PulseChainCache{entries: make(map[pulseChainCacheKey]*pulseChainCacheEntry)}
+}
+
+// selectorDigest hashes a selector set (names and ordinals) alone, without an owner or timestamp, so it
+// can be used as part of a cache key that already accounts for those separately.
+func selectorDigest(selectorNames [][]byte, selectorOrdinals []uint64) (digest [32]byte) {
+	h := sha256.New()
+	for i := 0; i < len(selectorNames); i++ {
+		h.Write(selectorNames[i])
+		if i < len(selectorOrdinals) {
+			var tmp [8]byte
+			binary.BigEndian.PutUint64(tmp[:], selectorOrdinals[i])
+			h.Write(tmp[:])
+		}
+	}
+	copy(digest[:], h.Sum(nil))
+	return
+}
+
+// valueAt returns the th64 chain value at the given depth (number of th64 applications from the seed),
+// computing and caching the seed first if this key has not been seen before. Depths may be requested in
+// any order, but the cache only stores the most recently requested depth: it extends forward cheaply and
+// recomputes from the seed whenever asked for a smaller depth than the last (i.e. more minutes).
+func (c *PulseChainCache) valueAt(owner *Owner, selectorNames [][]byte, selectorOrdinals []uint64, recordTimestamp uint64, depth uint) uint64 {
+	key := pulseChainCacheKey{
+		ophash:          owner.PrivateHash(),
+		recordTimestamp: recordTimestamp,
+		selectorDigest:  selectorDigest(selectorNames, selectorOrdinals),
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(c.entries) >= pulseChainCacheMaxEntries {
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+
+	e := c.entries[key]
+	if e == nil {
+		e = &pulseChainCacheEntry{value: pulseSeed(owner, selectorNames, selectorOrdinals, recordTimestamp), depth: 0}
+		c.entries[key] = e
+	}
+
+	if depth < e.depth {
+		e.value = pulseSeed(owner, selectorNames, selectorOrdinals, recordTimestamp)
+		e.depth = 0
+	}
+	for e.depth < depth {
+		e.value = th64(e.value)
+		e.depth++
+	}
+
+	return e.value
+}
+
+// NewPulseCached behaves exactly like NewPulse but consults cache for the chain's seed and last walked
+// chain value. Calls for the same record whose minutes never increase from one call to the next extend the
+// cached walk; a call with more minutes than the previous one for that record recomputes from the seed.
+func NewPulseCached(cache *PulseChainCache, owner *Owner, selectorNames [][]byte, selectorOrdinals []uint64, recordTimestamp uint64, minutes uint) (p Pulse, err error) {
+	if owner.Private == nil {
+		err = ErrPrivateKeyRequired
+		return
+	}
+	if minutes > RecordMaxPulseSpan {
+		err = ErrInvalidParameter
+		return
+	}
+
+	chain := cache.valueAt(owner, selectorNames, selectorOrdinals, recordTimestamp, RecordMaxPulseSpan-minutes)
+
+	p = packPulse(chain, minutes)
+	return
+}
+
+// verifiedTokenAt returns the terminal token reached by walking forward from chainKey for the number of
+// th64 steps implied by minutes, memoizing the result by (chainKey, minutes) so that verifying the same
+// pulse more than once (e.g. because it was delivered to more than one subscriber) does not repeat the
+// walk.
+func (c *PulseChainCache) verifiedTokenAt(chainKey uint64, minutes uint) uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := verifiedTokenCacheKey{chainKey: chainKey, minutes: minutes}
+
+	if c.verifiedTokens == nil {
+		c.verifiedTokens = make(map[verifiedTokenCacheKey]uint64)
+	}
+	if v, ok := c.verifiedTokens[key]; ok {
+		return v
+	}
+
+	if len(c.verifiedTokens) >= pulseChainCacheMaxEntries {
+		for k := range c.verifiedTokens {
+			delete(c.verifiedTokens, k)
+			break
+		}
+	}
+
+	v := th64n(chainKey, minutes)
+	c.verifiedTokens[key] = v
+	return v
+}
+
+// VerifyAgainst reports whether this pulse's token, computed by walking its hash chain forward to its
+// final value, equals expectedToken. This is what a record verifier should call to confirm that a
+// newly-received pulse actually advances the record it claims to. If cache is non-nil, the walk is
+// memoized in it by the pulse's chain key and minutes so that re-verifying the same pulse (as happens when it is
+// delivered to more than one subscriber) is O(1) after the first call; cache may be nil, in which case
+// VerifyAgainst always recomputes.
+func (p Pulse) VerifyAgainst(expectedToken uint64, cache *PulseChainCache) bool {
+	if cache != nil {
+		return cache.verifiedTokenAt(p.Key(), p.Minutes()) == expectedToken
+	}
+	return p.Token() == expectedToken
+}
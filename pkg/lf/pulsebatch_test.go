@@ -0,0 +1,224 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import "testing"
+
+// pulseTestMinutesList returns a handful of minute offsets, deliberately out of order, for correctness
+// tests that don't need BenchmarkNewPulse*'s larger pulseBenchCount.
+func pulseTestMinutesList() []uint {
+	return []uint{RecordMaxPulseSpan, RecordMaxPulseSpan / 2, RecordMaxPulseSpan / 3, 10, 1, 0}
+}
+
+func TestNewPulseBatchMatchesNewPulse(t *testing.T) {
+	owner, err := NewOwner(OwnerTypeNistP224)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selectorNames := [][]byte{[]byte("test/selector")}
+	selectorOrdinals := []uint64{0}
+	minutesList := pulseTestMinutesList()
+
+	batch, err := NewPulseBatch(owner, selectorNames, selectorOrdinals, 1, minutesList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != len(minutesList) {
+		t.Fatalf("len(batch) = %d, want %d", len(batch), len(minutesList))
+	}
+
+	for i, m := range minutesList {
+		want, err := NewPulse(owner, selectorNames, selectorOrdinals, 1, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if batch[i].String() != want.String() {
+			t.Errorf("minutes=%d: batch[%d] = %s, want %s (from NewPulse)", m, i, batch[i].String(), want.String())
+		}
+	}
+}
+
+func TestNewPulseCachedMatchesNewPulse(t *testing.T) {
+	owner, err := NewOwner(OwnerTypeNistP224)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selectorNames := [][]byte{[]byte("test/selector")}
+	selectorOrdinals := []uint64{0}
+	minutesList := pulseTestMinutesList()
+	cache := NewPulseChainCache()
+
+	for _, m := range minutesList {
+		got, err := NewPulseCached(cache, owner, selectorNames, selectorOrdinals, 1, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := NewPulse(owner, selectorNames, selectorOrdinals, 1, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != want.String() {
+			t.Errorf("minutes=%d: NewPulseCached = %s, want %s (from NewPulse)", m, got.String(), want.String())
+		}
+	}
+}
+
+func TestVerifyAgainstWithAndWithoutCache(t *testing.T) {
+	owner, err := NewOwner(OwnerTypeNistP224)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selectorNames := [][]byte{[]byte("test/selector")}
+	selectorOrdinals := []uint64{0}
+
+	p, err := NewPulse(owner, selectorNames, selectorOrdinals, 1, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	final, err := NewPulse(owner, selectorNames, selectorOrdinals, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedToken := final.Token()
+
+	if !p.VerifyAgainst(expectedToken, nil) {
+		t.Error("VerifyAgainst with nil cache should succeed against the real terminal token")
+	}
+	if p.VerifyAgainst(expectedToken+1, nil) {
+		t.Error("VerifyAgainst with nil cache should fail against a wrong token")
+	}
+
+	cache := NewPulseChainCache()
+	if !p.VerifyAgainst(expectedToken, cache) {
+		t.Error("VerifyAgainst with cache should succeed against the real terminal token")
+	}
+	// Second call should hit the memoized path and still agree.
+	if !p.VerifyAgainst(expectedToken, cache) {
+		t.Error("VerifyAgainst with cache should still succeed on the cached call")
+	}
+	if p.VerifyAgainst(expectedToken+1, cache) {
+		t.Error("VerifyAgainst with cache should fail against a wrong token")
+	}
+}
+
+func TestVerifyAgainstCacheKeysOnMinutesNotJustChainKey(t *testing.T) {
+	cache := NewPulseChainCache()
+
+	const chainKey uint64 = 42
+	p5 := packPulse(chainKey, 5)
+	p10 := packPulse(chainKey, 10)
+
+	want5 := p5.Token()
+	want10 := p10.Token()
+	if want5 == want10 {
+		t.Fatal("test setup: want5 and want10 must differ to exercise the collision")
+	}
+
+	if !p5.VerifyAgainst(want5, cache) {
+		t.Error("VerifyAgainst(p5, want5) with cache should succeed")
+	}
+	if !p10.VerifyAgainst(want10, cache) {
+		t.Error("VerifyAgainst(p10, want10) with cache should succeed even though it shares p5's chain key")
+	}
+	if p5.VerifyAgainst(want10, cache) {
+		t.Error("VerifyAgainst(p5, want10) with cache should fail: p5 must not reuse p10's cached terminal token")
+	}
+}
+
+const pulseBenchCount = 10000
+
+func benchPulseOwner(b *testing.B) *Owner {
+	owner, err := NewOwner(OwnerTypeNistP224)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return owner
+}
+
+// benchPulseMinutesList returns minute offsets in decreasing order, the order in which NewPulseBatch and
+// NewPulseCached can walk the hash chain forward one step per pulse.
+func benchPulseMinutesList() []uint {
+	minutesList := make([]uint, pulseBenchCount)
+	for i := range minutesList {
+		minutesList[i] = RecordMaxPulseSpan - uint(i%(RecordMaxPulseSpan+1))
+	}
+	return minutesList
+}
+
+// BenchmarkNewPulseSequential computes 10k pulses for the same record one at a time via NewPulse, each of
+// which recomputes the full th64n hash chain from scratch.
+func BenchmarkNewPulseSequential(b *testing.B) {
+	owner := benchPulseOwner(b)
+	selectorNames := [][]byte{[]byte("bench/selector")}
+	selectorOrdinals := []uint64{0}
+	minutesList := benchPulseMinutesList()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, m := range minutesList {
+			if _, err := NewPulse(owner, selectorNames, selectorOrdinals, 1, m); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkNewPulseBatch computes the same 10k pulses in one NewPulseBatch call, which pays for the th64n
+// chain walk once and reuses intermediate hashes for every subsequent minute offset.
+func BenchmarkNewPulseBatch(b *testing.B) {
+	owner := benchPulseOwner(b)
+	selectorNames := [][]byte{[]byte("bench/selector")}
+	selectorOrdinals := []uint64{0}
+	minutesList := benchPulseMinutesList()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewPulseBatch(owner, selectorNames, selectorOrdinals, 1, minutesList); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkNewPulseCached computes the same 10k pulses one at a time through NewPulseCached sharing a
+// single PulseChainCache, demonstrating that a long-lived cache gives the same amortized benefit as
+// NewPulseBatch for callers that generate pulses incrementally rather than all at once.
+func BenchmarkNewPulseCached(b *testing.B) {
+	owner := benchPulseOwner(b)
+	selectorNames := [][]byte{[]byte("bench/selector")}
+	selectorOrdinals := []uint64{0}
+	minutesList := benchPulseMinutesList()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache := NewPulseChainCache()
+		for _, m := range minutesList {
+			if _, err := NewPulseCached(cache, owner, selectorNames, selectorOrdinals, 1, m); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
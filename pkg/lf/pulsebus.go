@@ -0,0 +1,172 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import (
+	"bytes"
+	"sync"
+)
+
+// PulseSubscriberBufferSize is the number of matched pulses that can queue for a slow subscriber before it is dropped.
+const PulseSubscriberBufferSize = 64
+
+// PulseSubscriberFilter describes what a subscriber wants to hear about.
+// Owner restricts matches to pulses published by a given owner public key (or is empty to match any owner).
+// SelectorPrefixes restricts matches to records having at least one selector name with one of these prefixes
+// (or is empty to match any selector). MinMinutes discards pulses that represent less than this many minutes,
+// which is useful for clients that only care about pulses that move a record meaningfully forward in time.
+type PulseSubscriberFilter struct {
+	Owner            []byte
+	SelectorPrefixes [][]byte
+	MinMinutes       uint
+}
+
+// matches returns true if a pulse for a record with the given owner and selector names satisfies this filter.
+func (f *PulseSubscriberFilter) matches(ownerPublic []byte, selectorNames [][]byte, minutes uint) bool {
+	if minutes < f.MinMinutes {
+		return false
+	}
+	if len(f.Owner) > 0 && !bytes.Equal(f.Owner, ownerPublic) {
+		return false
+	}
+	if len(f.SelectorPrefixes) == 0 {
+		return true
+	}
+	for _, sn := range selectorNames {
+		for _, prefix := range f.SelectorPrefixes {
+			if bytes.HasPrefix(sn, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PulseUpdate is a single fan-out notification delivered to a PulseSubscriber.
+type PulseUpdate struct {
+	Pulse             Pulse
+	Key               uint64
+	Minutes           uint
+	MatchedRecordHash [32]byte
+}
+
+// PulseSubscriber receives a stream of PulseUpdate values matching its filter until Close is called.
+type PulseSubscriber struct {
+	Filter PulseSubscriberFilter
+
+	updates chan PulseUpdate
+	closed  bool
+	lock    sync.Mutex
+}
+
+// NewPulseSubscriber creates a new subscriber with the given filter. It is not live until registered with a PulseBus.
+func NewPulseSubscriber(filter PulseSubscriberFilter) *PulseSubscriber {
+	return &PulseSubscriber{
+		Filter:  filter,
+		updates: make(chan PulseUpdate, PulseSubscriberBufferSize),
+	}
+}
+
+// Updates returns the channel on which matched pulses are delivered.
+func (s *PulseSubscriber) Updates() <-chan PulseUpdate { return s.updates }
+
+// Close marks this subscriber as done and closes its update channel. It is safe to call more than once.
+func (s *PulseSubscriber) Close() {
+	s.lock.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.updates)
+	}
+	s.lock.Unlock()
+}
+
+// deliver attempts a non-blocking send of u to this subscriber. If the subscriber's buffer is full it is
+// dropped (closed) rather than allowed to stall the bus, since a slow reader should not block every other
+// subscriber on the node.
+func (s *PulseSubscriber) deliver(u PulseUpdate) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.updates <- u:
+	default:
+		s.closed = true
+		close(s.updates)
+	}
+}
+
+// PulseBus fans out every validated incoming pulse to whatever subscribers currently have a matching filter.
+// A node should hold one PulseBus and call Publish() each time it accepts a pulse for a known record.
+type PulseBus struct {
+	subscribers map[*PulseSubscriber]bool
+	lock        sync.RWMutex
+}
+
+// NewPulseBus creates an empty pulse bus.
+func NewPulseBus() *PulseBus {
+	return &PulseBus{subscribers: make(map[*PulseSubscriber]bool)}
+}
+
+// Subscribe registers a subscriber with this bus. The caller must eventually call Unsubscribe; Close alone
+// does not remove a subscriber from the bus.
+func (b *PulseBus) Subscribe(s *PulseSubscriber) {
+	b.lock.Lock()
+	b.subscribers[s] = true
+	b.lock.Unlock()
+}
+
+// Unsubscribe removes a subscriber from this bus without closing its update channel.
+func (b *PulseBus) Unsubscribe(s *PulseSubscriber) {
+	b.lock.Lock()
+	delete(b.subscribers, s)
+	b.lock.Unlock()
+}
+
+// Publish fans p out, tagged with recordHash, to every subscriber whose filter matches the given owner,
+// selector names, and p's minutes.
+// It is safe to call from multiple goroutines.
+func (b *PulseBus) Publish(p Pulse, ownerPublic []byte, selectorNames [][]byte, recordHash [32]byte) {
+	minutes := p.Minutes()
+	u := PulseUpdate{Pulse: p, Key: p.Key(), Minutes: minutes, MatchedRecordHash: recordHash}
+
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for s := range b.subscribers {
+		if s.Filter.matches(ownerPublic, selectorNames, minutes) {
+			s.deliver(u)
+		}
+	}
+}
+
+// Len returns the number of currently registered subscribers.
+func (b *PulseBus) Len() int {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return len(b.subscribers)
+}
@@ -0,0 +1,97 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+import "testing"
+
+func TestPulseSubscriberFilterMatches(t *testing.T) {
+	owner := []byte("owner-a")
+	otherOwner := []byte("owner-b")
+	selectors := [][]byte{[]byte("foo/bar"), []byte("baz/qux")}
+
+	cases := []struct {
+		name    string
+		filter  PulseSubscriberFilter
+		owner   []byte
+		sels    [][]byte
+		minutes uint
+		want    bool
+	}{
+		{"empty filter matches anything", PulseSubscriberFilter{}, owner, selectors, 0, true},
+		{"owner match", PulseSubscriberFilter{Owner: owner}, owner, selectors, 0, true},
+		{"owner mismatch", PulseSubscriberFilter{Owner: owner}, otherOwner, selectors, 0, false},
+		{"selector prefix match", PulseSubscriberFilter{SelectorPrefixes: [][]byte{[]byte("foo/")}}, owner, selectors, 0, true},
+		{"selector prefix mismatch", PulseSubscriberFilter{SelectorPrefixes: [][]byte{[]byte("nope/")}}, owner, selectors, 0, false},
+		{"below MinMinutes", PulseSubscriberFilter{MinMinutes: 10}, owner, selectors, 5, false},
+		{"at MinMinutes", PulseSubscriberFilter{MinMinutes: 10}, owner, selectors, 10, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.filter.matches(c.owner, c.sels, c.minutes); got != c.want {
+				t.Errorf("matches() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestPulseBusPublishOnlyReachesMatchingSubscribers(t *testing.T) {
+	bus := NewPulseBus()
+
+	wantOwner := []byte("owner-a")
+	matching := NewPulseSubscriber(PulseSubscriberFilter{Owner: wantOwner})
+	nonMatching := NewPulseSubscriber(PulseSubscriberFilter{Owner: []byte("owner-b")})
+	bus.Subscribe(matching)
+	bus.Subscribe(nonMatching)
+	defer bus.Unsubscribe(matching)
+	defer bus.Unsubscribe(nonMatching)
+	defer matching.Close()
+	defer nonMatching.Close()
+
+	if bus.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", bus.Len())
+	}
+
+	var recordHash [32]byte
+	recordHash[0] = 1
+	bus.Publish(Pulse(make([]byte, PulseSize)), wantOwner, [][]byte{[]byte("sel")}, recordHash)
+
+	select {
+	case u := <-matching.Updates():
+		if u.MatchedRecordHash != recordHash {
+			t.Errorf("got hash %x, want %x", u.MatchedRecordHash, recordHash)
+		}
+	default:
+		t.Fatal("expected matching subscriber to receive the update")
+	}
+
+	select {
+	case <-nonMatching.Updates():
+		t.Fatal("non-matching subscriber should not have received anything")
+	default:
+	}
+}
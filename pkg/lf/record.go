@@ -0,0 +1,43 @@
+/*
+ * LF: Global Fully Replicated Key/Value Store
+ * Copyright (C) 2018-2019  ZeroTier, Inc.  https://www.zerotier.com/
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ *
+ * --
+ *
+ * You can be released from the requirements of the license by purchasing
+ * a commercial license. Buying such a license is mandatory as soon as you
+ * develop commercial closed-source software that incorporates or links
+ * directly against ZeroTier software without disclosing the source code
+ * of your own application.
+ */
+
+package lf
+
+// Record is an entry in the LF data store.
+//
+// CertChains holds zero or more alternative certificate chains that can authorize this record; see
+// VerifyCertificates. Most records carry at most one chain. Each chain must be ordered leaf-first, i.e.
+// chain[0] is the certificate that signs (or otherwise vouches for) the record itself and chain[len-1] is
+// the root; pickChain relies on this order to find a chain's root when matching it against
+// NodeConfig.PreferredCertIssuers.
+//
+// AliasTarget, when non-empty, marks this record as a CNAME-style alias rather than a record with its own
+// pulse chain: it is an encoded AliasHop (see EncodeAliasHop) identifying another owner's selector set that
+// pulse verification should be redirected to instead. See ResolveAliasChain and VerifyPulse.
+type Record struct {
+	CertChains  [][]Certificate
+	AliasTarget []byte
+}